@@ -0,0 +1,125 @@
+package nap
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Balancer picks one of the given slaves to serve the next read. replicas
+// never includes the master and may be a filtered view of the full slave
+// list (e.g. with unhealthy or stale replicas removed); indices holds, for
+// each entry in replicas, the position it occupies in that full list -- the
+// same indexing used by StatsSlave and Config.Weights -- so a balancer that
+// keys state off a replica's identity rather than its transient position in
+// replicas (for example WeightedRoundRobinBalancer) stays correct under
+// filtering. Pick returns an index into replicas, not into indices.
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Balancer interface {
+	Pick(replicas []*sqlx.DB, indices []int) int
+}
+
+// RoundRobinBalancer cycles through replicas in order. It is the default
+// balancer used by Open and OpenWithConfig.
+type RoundRobinBalancer struct {
+	count uint64
+}
+
+// Pick returns the next replica in round-robin order.
+func (b *RoundRobinBalancer) Pick(replicas []*sqlx.DB, indices []int) int {
+	return int(atomic.AddUint64(&b.count, 1) % uint64(len(replicas)))
+}
+
+// RandomBalancer picks a replica uniformly at random on every read.
+type RandomBalancer struct{}
+
+// Pick returns a uniformly random replica index.
+func (RandomBalancer) Pick(replicas []*sqlx.DB, indices []int) int {
+	return rand.Intn(len(replicas))
+}
+
+// LeastInUseBalancer picks the replica with the fewest connections
+// currently in use, based on sqlx.DB.Stats. It favors replicas that are
+// recovering from load over ones that are already busy.
+type LeastInUseBalancer struct{}
+
+// Pick returns the index of the replica with the lowest Stats().InUse.
+func (LeastInUseBalancer) Pick(replicas []*sqlx.DB, indices []int) int {
+	best := 0
+	bestInUse := replicas[0].Stats().InUse
+	for i := 1; i < len(replicas); i++ {
+		if inUse := replicas[i].Stats().InUse; inUse < bestInUse {
+			best, bestInUse = i, inUse
+		}
+	}
+	return best
+}
+
+// WeightedRoundRobinBalancer selects replicas in proportion to Weights
+// using smooth weighted round-robin, so heavier replicas are picked more
+// often without bursts of consecutive picks landing on the same replica.
+// Weights is indexed by a replica's position in the full slave list (as
+// passed via Pick's indices argument, the same indexing StatsSlave uses),
+// not by its position in a filtered replicas slice; a missing or
+// non-positive weight defaults to 1.
+type WeightedRoundRobinBalancer struct {
+	Weights []int
+
+	mu      sync.Mutex
+	current map[int]int // keyed by true slave index, not slice position
+}
+
+// Pick returns the next replica according to the smooth weighted
+// round-robin algorithm.
+func (b *WeightedRoundRobinBalancer) Pick(replicas []*sqlx.DB, indices []int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.current == nil {
+		b.current = make(map[int]int, len(indices))
+	}
+
+	total := 0
+	best := 0
+	for pos, idx := range indices {
+		weight := 1
+		if idx < len(b.Weights) && b.Weights[idx] > 0 {
+			weight = b.Weights[idx]
+		}
+		b.current[idx] += weight
+		total += weight
+		if b.current[idx] > b.current[indices[best]] {
+			best = pos
+		}
+	}
+	b.current[indices[best]] -= total
+
+	return best
+}
+
+// P2CBalancer implements power-of-two-choices: it samples two distinct
+// random replicas and picks the one with fewer connections in use. It
+// approximates LeastInUseBalancer's load spreading at O(1) instead of
+// O(n) per pick.
+type P2CBalancer struct{}
+
+// Pick samples two replicas and returns the less busy of the two.
+func (P2CBalancer) Pick(replicas []*sqlx.DB, indices []int) int {
+	n := len(replicas)
+	if n == 1 {
+		return 0
+	}
+
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	if replicas[j].Stats().InUse < replicas[i].Stats().InUse {
+		return j
+	}
+	return i
+}
@@ -0,0 +1,62 @@
+package nap
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestWeightedRoundRobinBalancerDistribution(t *testing.T) {
+	b := &WeightedRoundRobinBalancer{Weights: []int{1, 1, 2}}
+
+	replicas := make([]*sqlx.DB, 3) // WRR.Pick never dereferences these
+	indices := identityIndices(3)
+
+	counts := map[int]int{}
+	const picks = 400
+	for i := 0; i < picks; i++ {
+		pos := b.Pick(replicas, indices)
+		counts[indices[pos]]++
+	}
+
+	// Weights 1:1:2 over 400 picks should land close to 100/100/200.
+	wantRatio := map[int]float64{0: 0.25, 1: 0.25, 2: 0.5}
+	for idx, want := range wantRatio {
+		got := float64(counts[idx]) / picks
+		if diff := got - want; diff < -0.05 || diff > 0.05 {
+			t.Errorf("replica %d got ratio %.3f, want ~%.3f", idx, got, want)
+		}
+	}
+}
+
+func TestWeightedRoundRobinBalancerKeepsWeightUnderFiltering(t *testing.T) {
+	// Regression test: evicting replica 0 must not cause replica 1 to
+	// silently inherit replica 0's (lower) weight, or replica 2 to
+	// inherit replica 1's. Weights are keyed by true slave index, not by
+	// position in the filtered replicas slice passed to Pick.
+	b := &WeightedRoundRobinBalancer{Weights: []int{1, 1, 100}}
+
+	full := make([]*sqlx.DB, 3)
+	fullIdx := identityIndices(3)
+	const picks = 500
+	fullCounts := map[int]int{}
+	for i := 0; i < picks; i++ {
+		pos := b.Pick(full, fullIdx)
+		fullCounts[fullIdx[pos]]++
+	}
+	if ratio := float64(fullCounts[2]) / picks; ratio < 0.9 {
+		t.Fatalf("full-set: replica 2 got ratio %.3f, want >= 0.9 (weights 1:1:100)", ratio)
+	}
+
+	// Now filter out replica 0, as pickSlave does when it's evicted.
+	filtered := make([]*sqlx.DB, 2)
+	filteredIdx := []int{1, 2}
+	filteredCounts := map[int]int{}
+	for i := 0; i < picks; i++ {
+		pos := b.Pick(filtered, filteredIdx)
+		filteredCounts[filteredIdx[pos]]++
+	}
+	if ratio := float64(filteredCounts[2]) / picks; ratio < 0.9 {
+		t.Fatalf("filtered-set: replica 2 got ratio %.3f, want >= 0.9 (its own weight of 100 must survive filtering)", ratio)
+	}
+}
@@ -1,10 +1,11 @@
 package nap
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -14,31 +15,145 @@ import (
 // forming a single master multiple slaves topology.
 // Reads and writes are automatically directed to the correct physical db.
 type DB struct {
-	pdbs  []*sqlx.DB // Physical databases
-	count uint64     // Monotonically incrementing counter on each query
+	pdbs     []*sqlx.DB // Physical databases
+	balancer Balancer   // Picks a slave among pdbs[1:] for each read
+
+	cfg             Config
+	health          []*replicaHealth // one per slave; nil if health checking is disabled
+	stopHealthCheck chan struct{}
+	// stopHealthCheckOnce guards stopHealthCheck's close. It is a pointer
+	// so that WithPolicy's shallow copy shares it with the original DB:
+	// both handles close the same channel, and whichever Close() call
+	// happens first performs the close, keeping Close() idempotent no
+	// matter which handle (or how many times) it's called on.
+	stopHealthCheckOnce *sync.Once
+	policy              ReadPolicy // zero value is PolicyAny
+}
+
+// Config controls how OpenWithConfig builds a DB.
+type Config struct {
+	// Balancer selects which slave serves the next read. Defaults to a
+	// RoundRobinBalancer, matching Open's behavior, if nil.
+	Balancer Balancer
+
+	// Weights are used by balancers that support weighted selection (for
+	// example WeightedRoundRobinBalancer), one per slave in the order
+	// given in dataSourceNames. It is ignored by balancers that don't
+	// support weights, and by a Balancer supplied with its own weights.
+	Weights []int
+
+	// MaxIdleConns, MaxOpenConns and ConnMaxLifetime are applied to every
+	// underlying physical db as soon as it is opened, mirroring
+	// SetMaxIdleConns, SetMaxOpenConns and SetConnMaxLifetime. Zero means
+	// leave the driver's default in place.
+	MaxIdleConns    int
+	MaxOpenConns    int
+	ConnMaxLifetime time.Duration
+
+	// HealthCheckInterval, if positive, enables a background health
+	// checker that pings every slave on this interval and evicts it from
+	// read selection after UnhealthyThreshold consecutive failures,
+	// re-admitting it after RecoveryThreshold consecutive successes.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout bounds each health-check ping. Defaults to
+	// HealthCheckInterval if zero.
+	HealthCheckTimeout time.Duration
+
+	// UnhealthyThreshold is the number of consecutive failed pings before
+	// a slave is evicted. Defaults to 1.
+	UnhealthyThreshold int
+
+	// RecoveryThreshold is the number of consecutive successful pings
+	// before an evicted slave is re-admitted. Defaults to 1.
+	RecoveryThreshold int
+
+	// FallbackToMasterOnAllSlavesDown routes reads to the master when
+	// every slave has been evicted, instead of returning
+	// ErrNoHealthySlaves. Only consulted when HealthCheckInterval is set.
+	FallbackToMasterOnAllSlavesDown bool
+
+	// LagSampler, sampled on the same interval as the health checker,
+	// measures each slave's replication lag so PolicyFresh can route
+	// around stale replicas. Ignored unless HealthCheckInterval is set.
+	LagSampler LagSampler
+
+	// TxMaxAttempts caps how many times RunInTx invokes its closure,
+	// including the first try, when a retryable serialization failure
+	// occurs. Defaults to 3 when zero.
+	TxMaxAttempts int
+
+	// TxBackoff returns how long RunInTx sleeps before retrying attempt
+	// (1-based: the attempt that just failed). Defaults to
+	// 20ms * attempt when nil.
+	TxBackoff func(attempt int) time.Duration
+
+	// TxIsRetryable reports whether err is a transient serialization
+	// failure that RunInTx should retry. Defaults to
+	// IsSerializationFailure when nil.
+	TxIsRetryable func(err error) bool
 }
 
 // Open concurrently opens each underlying physical db.
 // dataSourceNames must be a semi-comma separated list of DSNs with the first
 // one being used as the master and the rest as slaves.
+// Slaves are selected round-robin; use OpenWithConfig for other strategies.
 func Open(driverName, dataSourceNames string) (*DB, error) {
+	return OpenWithConfig(driverName, dataSourceNames, Config{})
+}
+
+// OpenWithConfig concurrently opens each underlying physical db, as Open
+// does, but lets cfg customize the slave-selection strategy and per-db pool
+// defaults.
+func OpenWithConfig(driverName, dataSourceNames string, cfg Config) (*DB, error) {
 	conns := strings.Split(dataSourceNames, ";")
-	db := &DB{pdbs: make([]*sqlx.DB, len(conns))}
+
+	balancer := cfg.Balancer
+	if balancer == nil {
+		balancer = &RoundRobinBalancer{}
+	}
+	if wrr, ok := balancer.(*WeightedRoundRobinBalancer); ok && wrr.Weights == nil {
+		wrr.Weights = cfg.Weights
+	}
+
+	db := &DB{pdbs: make([]*sqlx.DB, len(conns)), balancer: balancer, cfg: cfg}
 
 	err := scatter(len(db.pdbs), func(i int) (err error) {
 		db.pdbs[i], err = sqlx.Open(driverName, conns[i])
-		return err
+		if err != nil {
+			return err
+		}
+		if cfg.MaxIdleConns != 0 {
+			db.pdbs[i].SetMaxIdleConns(cfg.MaxIdleConns)
+		}
+		if cfg.MaxOpenConns != 0 {
+			db.pdbs[i].SetMaxOpenConns(cfg.MaxOpenConns)
+		}
+		if cfg.ConnMaxLifetime != 0 {
+			db.pdbs[i].SetConnMaxLifetime(cfg.ConnMaxLifetime)
+		}
+		return nil
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.HealthCheckInterval > 0 && len(db.pdbs) > 1 {
+		db.startHealthChecks()
+	}
+
 	return db, nil
 }
 
-// Close closes all physical databases concurrently, releasing any open resources.
+// Close closes all physical databases concurrently, releasing any open
+// resources, and stops the background health checker if one is running.
+// Close is idempotent and safe to call more than once, including through a
+// *DB returned by WithPolicy for the same underlying connections.
 func (db *DB) Close() error {
+	if db.stopHealthCheck != nil {
+		db.stopHealthCheckOnce.Do(func() { close(db.stopHealthCheck) })
+	}
 	return scatter(len(db.pdbs), func(i int) error {
 		return db.pdbs[i].Close()
 	})
@@ -54,6 +169,13 @@ func (db *DB) Begin() (*sql.Tx, error) {
 	return db.pdbs[0].Begin()
 }
 
+// BeginTx starts a transaction on the master with the provided context and
+// options. The provided context is used until the transaction is committed
+// or rolled back.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return db.pdbs[0].BeginTx(ctx, opts)
+}
+
 // Exec executes a query without returning any rows.
 // The args are for any placeholder parameters in the query.
 // Exec uses the master as the underlying physical db.
@@ -61,6 +183,13 @@ func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
 	return db.pdbs[0].Exec(query, args...)
 }
 
+// ExecContext executes a query without returning any rows.
+// The args are for any placeholder parameters in the query.
+// ExecContext uses the master as the underlying physical db.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.pdbs[0].ExecContext(ctx, query, args...)
+}
+
 // Ping verifies if a connection to each physical database is still alive,
 // establishing a connection if necessary.
 func (db *DB) Ping() error {
@@ -69,6 +198,33 @@ func (db *DB) Ping() error {
 	})
 }
 
+// PingContext verifies if a connection to each physical database is still
+// alive, establishing a connection if necessary. Outstanding pings are
+// cancelled as soon as ctx is done or the first ping fails, and the error of
+// that first failing ping is returned.
+func (db *DB) PingContext(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once     sync.Once
+		firstErr error
+	)
+	if err := scatter(len(db.pdbs), func(i int) error {
+		if err := db.pdbs[i].PingContext(ctx); err != nil {
+			once.Do(func() {
+				firstErr = err
+				cancel()
+			})
+			return err
+		}
+		return nil
+	}); err != nil {
+		return firstErr
+	}
+	return nil
+}
+
 // SetMaxIdleConns sets the maximum number of connections in the idle
 // connection pool for each underlying physical db.
 // If MaxOpenConns is greater than 0 but less than the new MaxIdleConns then the
@@ -101,9 +257,63 @@ func (db *DB) SetConnMaxLifetime(d time.Duration) {
 	}
 }
 
-// Slave returns one of the physical databases which is a slave
+// SetConnMaxIdleTime sets the maximum amount of time a connection may be
+// idle before being closed, on each underlying physical db.
+// Expired connections may be closed lazily before reuse.
+// If d <= 0, connections are not closed due to a connection's idle time.
+func (db *DB) SetConnMaxIdleTime(d time.Duration) {
+	for i := range db.pdbs {
+		db.pdbs[i].SetConnMaxIdleTime(d)
+	}
+}
+
+// Stats returns database statistics for each of the underlying physical
+// databases, the master first followed by the slaves in the order they
+// were given to Open.
+func (db *DB) Stats() []sql.DBStats {
+	stats := make([]sql.DBStats, len(db.pdbs))
+	for i := range db.pdbs {
+		stats[i] = db.pdbs[i].Stats()
+	}
+	return stats
+}
+
+// StatsMaster returns database statistics for the master.
+func (db *DB) StatsMaster() sql.DBStats {
+	return db.Master().Stats()
+}
+
+// StatsSlave returns database statistics for the i-th slave.
+func (db *DB) StatsSlave(i int) sql.DBStats {
+	return db.pdbs[1+i].Stats()
+}
+
+// SlaveStatus reports the health-checker's current view of each slave, in
+// the same order as StatsSlave. It returns nil if health checking is
+// disabled.
+func (db *DB) SlaveStatus() []ReplicaHealth {
+	if db.health == nil {
+		return nil
+	}
+	statuses := make([]ReplicaHealth, len(db.health))
+	for i, h := range db.health {
+		statuses[i] = ReplicaHealth{Index: i, Healthy: h.isHealthy()}
+	}
+	return statuses
+}
+
+// Slave returns one of the physical databases which is a slave, chosen by
+// db's Balancer among the slaves the health checker currently considers
+// healthy. Since Slave cannot report an error, it falls back to the master
+// when every slave is evicted, regardless of
+// Config.FallbackToMasterOnAllSlavesDown; callers that need
+// ErrNoHealthySlaves should use a Slave-routed method such as Select.
 func (db *DB) Slave() *sqlx.DB {
-	return db.pdbs[db.slave(len(db.pdbs))]
+	slave, err := db.pickSlave()
+	if err != nil {
+		return db.Master()
+	}
+	return slave
 }
 
 // Master returns the master physical database
@@ -111,11 +321,90 @@ func (db *DB) Master() *sqlx.DB {
 	return db.pdbs[0]
 }
 
-func (db *DB) slave(n int) int {
-	if n <= 1 {
-		return 0
+// pickSlave selects a physical db to serve the next Slave-routed read,
+// honoring db.policy:
+//
+//   - PolicyMaster always returns the master.
+//   - PolicyFresh returns a slave whose last-sampled lag is at most
+//     maxLag, falling back to the master if none qualifies.
+//   - PolicyAny (the default) uses db.balancer over the slaves the health
+//     checker currently considers healthy. Every slave is considered
+//     healthy when health checking is disabled. It returns
+//     ErrNoHealthySlaves once every slave has been evicted, unless
+//     Config.FallbackToMasterOnAllSlavesDown is set, in which case it
+//     returns the master instead.
+func (db *DB) pickSlave() (*sqlx.DB, error) {
+	if db.policy.kind == readPolicyMaster {
+		return db.Master(), nil
+	}
+
+	if len(db.pdbs) <= 1 {
+		return db.pdbs[0], nil
 	}
-	return int(1 + (atomic.AddUint64(&db.count, 1) % uint64(n-1)))
+	slaves := db.pdbs[1:]
+
+	if db.policy.kind == readPolicyFresh {
+		return db.pickFreshSlave(slaves), nil
+	}
+
+	if db.health == nil {
+		return slaves[db.balancer.Pick(slaves, identityIndices(len(slaves)))], nil
+	}
+
+	healthy := make([]*sqlx.DB, 0, len(slaves))
+	healthyIdx := make([]int, 0, len(slaves))
+	for i, s := range slaves {
+		if db.health[i].isHealthy() {
+			healthy = append(healthy, s)
+			healthyIdx = append(healthyIdx, i)
+		}
+	}
+
+	if len(healthy) == 0 {
+		if db.cfg.FallbackToMasterOnAllSlavesDown {
+			return db.Master(), nil
+		}
+		return nil, ErrNoHealthySlaves
+	}
+
+	return healthy[db.balancer.Pick(healthy, healthyIdx)], nil
+}
+
+// pickFreshSlave implements the PolicyFresh half of pickSlave: it returns
+// a healthy slave whose sampled lag is within db.policy.maxLag, or the
+// master if none qualifies (including when no lag samples exist yet).
+func (db *DB) pickFreshSlave(slaves []*sqlx.DB) *sqlx.DB {
+	if db.health == nil {
+		return db.Master()
+	}
+
+	fresh := make([]*sqlx.DB, 0, len(slaves))
+	freshIdx := make([]int, 0, len(slaves))
+	for i, s := range slaves {
+		if !db.health[i].isHealthy() {
+			continue
+		}
+		if lag, ok := db.health[i].lag(); ok && lag <= db.policy.maxLag {
+			fresh = append(fresh, s)
+			freshIdx = append(freshIdx, i)
+		}
+	}
+
+	if len(fresh) == 0 {
+		return db.Master()
+	}
+
+	return fresh[db.balancer.Pick(fresh, freshIdx)]
+}
+
+// identityIndices returns []int{0, 1, ..., n-1}, the indices slice to pass
+// to Balancer.Pick when replicas hasn't been filtered.
+func identityIndices(n int) []int {
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
 }
 
 // Preparex prepares a statement that connects to the master.
@@ -124,16 +413,119 @@ func (db *DB) Preparex(query string) (*sqlx.Stmt, error) {
 }
 
 // PreparexSlave prepares a statement that connects with one of the slaves.
+// It returns ErrNoHealthySlaves per the rules documented on pickSlave.
 func (db *DB) PreparexSlave(query string) (*sqlx.Stmt, error) {
-	return db.Slave().Preparex(query)
+	slave, err := db.pickSlave()
+	if err != nil {
+		return nil, err
+	}
+	return slave.Preparex(query)
+}
+
+// PrepareContext creates a prepared statement that connects to the master
+// for later queries or executions.
+func (db *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return db.Master().PrepareContext(ctx, query)
+}
+
+// PrepareContextSlave creates a prepared statement that connects with one
+// of the slaves for later queries or executions. It returns
+// ErrNoHealthySlaves per the rules documented on pickSlave.
+func (db *DB) PrepareContextSlave(ctx context.Context, query string) (*sql.Stmt, error) {
+	slave, err := db.pickSlave()
+	if err != nil {
+		return nil, err
+	}
+	return slave.PrepareContext(ctx, query)
+}
+
+// PreparexContext prepares a statement that connects to the master.
+func (db *DB) PreparexContext(ctx context.Context, query string) (*sqlx.Stmt, error) {
+	return db.Master().PreparexContext(ctx, query)
+}
+
+// PreparexContextSlave prepares a statement that connects with one of the
+// slaves. It returns ErrNoHealthySlaves per the rules documented on
+// pickSlave.
+func (db *DB) PreparexContextSlave(ctx context.Context, query string) (*sqlx.Stmt, error) {
+	slave, err := db.pickSlave()
+	if err != nil {
+		return nil, err
+	}
+	return slave.PreparexContext(ctx, query)
 }
 
-// Select performs a sqlx select against one of the slaves.
+// Select performs a sqlx select against one of the slaves. It returns
+// ErrNoHealthySlaves per the rules documented on pickSlave.
 func (db *DB) Select(dest interface{}, query string, args ...interface{}) error {
-	return db.Slave().Select(dest, query, args...)
+	slave, err := db.pickSlave()
+	if err != nil {
+		return err
+	}
+	return slave.Select(dest, query, args...)
+}
+
+// SelectContext performs a sqlx select against one of the slaves. It
+// returns ErrNoHealthySlaves per the rules documented on pickSlave.
+func (db *DB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	slave, err := db.pickSlave()
+	if err != nil {
+		return err
+	}
+	return slave.SelectContext(ctx, dest, query, args...)
 }
 
-// QueryxSlave performs an sqlx Queryx call against one of the slaves.
+// GetContext performs a sqlx get against one of the slaves. It returns
+// ErrNoHealthySlaves per the rules documented on pickSlave.
+func (db *DB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	slave, err := db.pickSlave()
+	if err != nil {
+		return err
+	}
+	return slave.GetContext(ctx, dest, query, args...)
+}
+
+// QueryxSlave performs an sqlx Queryx call against one of the slaves. It
+// returns ErrNoHealthySlaves per the rules documented on pickSlave.
 func (db *DB) QueryxSlave(query string, args ...interface{}) (*sqlx.Rows, error) {
-	return db.Slave().Queryx(query, args...)
+	slave, err := db.pickSlave()
+	if err != nil {
+		return nil, err
+	}
+	return slave.Queryx(query, args...)
+}
+
+// QueryxContext performs an sqlx Queryx call against one of the slaves. It
+// returns ErrNoHealthySlaves per the rules documented on pickSlave.
+func (db *DB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	slave, err := db.pickSlave()
+	if err != nil {
+		return nil, err
+	}
+	return slave.QueryxContext(ctx, query, args...)
+}
+
+// QueryRowxContext performs an sqlx QueryRowx call against one of the
+// slaves. Unlike the other Slave-routed methods, it cannot report
+// ErrNoHealthySlaves synchronously, since sqlx.Row defers its error until
+// Scan; it falls back to the master when every slave is evicted, as Slave
+// does.
+func (db *DB) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	return db.Slave().QueryRowxContext(ctx, query, args...)
+}
+
+// NamedExecContext executes a named query without returning any rows.
+// NamedExecContext uses the master as the underlying physical db.
+func (db *DB) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	return db.Master().NamedExecContext(ctx, query, arg)
+}
+
+// NamedQueryContext performs a named query against one of the slaves. It
+// returns ErrNoHealthySlaves per the rules documented on pickSlave.
+func (db *DB) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error) {
+	slave, err := db.pickSlave()
+	if err != nil {
+		return nil, err
+	}
+	return slave.NamedQueryContext(ctx, query, arg)
 }
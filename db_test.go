@@ -0,0 +1,56 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+// newMockDB builds a *DB backed by n sqlmock physical databases (pdbs[0] is
+// the master, the rest slaves), returning their mocks in the same order.
+func newMockDB(t *testing.T, n int) (*DB, []sqlmock.Sqlmock) {
+	t.Helper()
+
+	pdbs := make([]*sqlx.DB, n)
+	mocks := make([]sqlmock.Sqlmock, n)
+	for i := 0; i < n; i++ {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("sqlmock.New: %v", err)
+		}
+		t.Cleanup(func() { mockDB.Close() })
+		pdbs[i] = sqlx.NewDb(mockDB, "sqlmock")
+		mocks[i] = mock
+	}
+
+	return &DB{pdbs: pdbs}, mocks
+}
+
+func TestPingContextReturnsFirstFailureNotCancellation(t *testing.T) {
+	mockMaster, masterMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { mockMaster.Close() })
+	mockSlave, slaveMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { mockSlave.Close() })
+
+	db := &DB{pdbs: []*sqlx.DB{
+		sqlx.NewDb(mockMaster, "sqlmock"),
+		sqlx.NewDb(mockSlave, "sqlmock"),
+	}}
+
+	boom := errors.New("boom")
+	masterMock.ExpectPing().WillReturnError(boom)
+	slaveMock.ExpectPing()
+
+	if err := db.PingContext(context.Background()); !errors.Is(err, boom) {
+		t.Fatalf("PingContext() error = %v, want the real ping failure %v", err, boom)
+	}
+}
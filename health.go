@@ -0,0 +1,139 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNoHealthySlaves is returned by Slave-routed reads when every slave has
+// been evicted by the health checker and
+// Config.FallbackToMasterOnAllSlavesDown is false.
+var ErrNoHealthySlaves = errors.New("nap: no healthy slaves available")
+
+// ReplicaHealth reports the health checker's view of a single slave.
+type ReplicaHealth struct {
+	Index   int // Index into the slave list, as used by StatsSlave
+	Healthy bool
+}
+
+// replicaHealth tracks consecutive ping results for one slave so it can be
+// evicted and re-admitted independently of the others.
+type replicaHealth struct {
+	healthy        int32 // atomic bool: 1 healthy, 0 evicted
+	consecutiveOK  int32
+	consecutiveBad int32
+	lagNanos       int64 // atomic; -1 means no sample has been taken yet
+}
+
+func newReplicaHealth() *replicaHealth {
+	return &replicaHealth{healthy: 1, lagNanos: -1}
+}
+
+func (h *replicaHealth) isHealthy() bool {
+	return atomic.LoadInt32(&h.healthy) == 1
+}
+
+// lag returns the most recently sampled replication lag, or false if no
+// sample has been taken yet (LagSampler unset, or the first tick hasn't run).
+func (h *replicaHealth) lag() (time.Duration, bool) {
+	n := atomic.LoadInt64(&h.lagNanos)
+	if n < 0 {
+		return 0, false
+	}
+	return time.Duration(n), true
+}
+
+func (h *replicaHealth) setLag(d time.Duration) {
+	atomic.StoreInt64(&h.lagNanos, int64(d))
+}
+
+// recordResult folds in the outcome of one health-check ping, evicting the
+// replica after unhealthyThreshold consecutive failures and re-admitting it
+// after recoveryThreshold consecutive successes.
+func (h *replicaHealth) recordResult(ok bool, unhealthyThreshold, recoveryThreshold int) {
+	if ok {
+		atomic.StoreInt32(&h.consecutiveBad, 0)
+		if !h.isHealthy() && int(atomic.AddInt32(&h.consecutiveOK, 1)) >= recoveryThreshold {
+			atomic.StoreInt32(&h.healthy, 1)
+			atomic.StoreInt32(&h.consecutiveOK, 0)
+		}
+		return
+	}
+
+	atomic.StoreInt32(&h.consecutiveOK, 0)
+	if h.isHealthy() && int(atomic.AddInt32(&h.consecutiveBad, 1)) >= unhealthyThreshold {
+		atomic.StoreInt32(&h.healthy, 0)
+		atomic.StoreInt32(&h.consecutiveBad, 0)
+	}
+}
+
+// startHealthChecks initializes per-slave health state and launches the
+// background checker described by db.cfg. Callers must only invoke this
+// once, after db.pdbs has been fully populated.
+func (db *DB) startHealthChecks() {
+	slaves := db.pdbs[1:]
+
+	db.health = make([]*replicaHealth, len(slaves))
+	for i := range db.health {
+		db.health[i] = newReplicaHealth()
+	}
+	db.stopHealthCheck = make(chan struct{})
+	db.stopHealthCheckOnce = &sync.Once{}
+
+	timeout := db.cfg.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = db.cfg.HealthCheckInterval
+	}
+	unhealthyThreshold := db.cfg.UnhealthyThreshold
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = 1
+	}
+	recoveryThreshold := db.cfg.RecoveryThreshold
+	if recoveryThreshold <= 0 {
+		recoveryThreshold = 1
+	}
+
+	go db.runHealthChecks(slaves, db.cfg.HealthCheckInterval, timeout, unhealthyThreshold, recoveryThreshold)
+}
+
+// runHealthChecks pings every slave once per interval, in parallel,
+// updating its replicaHealth with the outcome, and, if Config.LagSampler is
+// set, samples its replication lag on the same tick. It returns once
+// db.stopHealthCheck is closed.
+func (db *DB) runHealthChecks(slaves []*sqlx.DB, interval, timeout time.Duration, unhealthyThreshold, recoveryThreshold int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sampler := db.cfg.LagSampler
+
+	for {
+		select {
+		case <-db.stopHealthCheck:
+			return
+		case <-ticker.C:
+			for i, slave := range slaves {
+				i, slave := i, slave
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), timeout)
+					defer cancel()
+					db.health[i].recordResult(slave.PingContext(ctx) == nil, unhealthyThreshold, recoveryThreshold)
+				}()
+
+				if sampler != nil {
+					go func() {
+						ctx, cancel := context.WithTimeout(context.Background(), timeout)
+						defer cancel()
+						if lag, err := sampler.SampleLag(ctx, slave); err == nil {
+							db.health[i].setLag(lag)
+						}
+					}()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package nap
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestReplicaHealthRecordResultThresholds(t *testing.T) {
+	const unhealthyThreshold = 3
+	const recoveryThreshold = 2
+
+	h := newReplicaHealth()
+	if !h.isHealthy() {
+		t.Fatal("newReplicaHealth() should start healthy")
+	}
+
+	// Failures below the threshold must not evict.
+	h.recordResult(false, unhealthyThreshold, recoveryThreshold)
+	h.recordResult(false, unhealthyThreshold, recoveryThreshold)
+	if !h.isHealthy() {
+		t.Fatal("replica evicted before reaching unhealthyThreshold consecutive failures")
+	}
+
+	// A success in between resets the failure streak.
+	h.recordResult(true, unhealthyThreshold, recoveryThreshold)
+	h.recordResult(false, unhealthyThreshold, recoveryThreshold)
+	h.recordResult(false, unhealthyThreshold, recoveryThreshold)
+	if !h.isHealthy() {
+		t.Fatal("an intervening success should have reset the consecutive-failure streak")
+	}
+
+	// The threshold-th consecutive failure evicts.
+	h.recordResult(false, unhealthyThreshold, recoveryThreshold)
+	if h.isHealthy() {
+		t.Fatal("replica should be evicted after unhealthyThreshold consecutive failures")
+	}
+
+	// Successes below recoveryThreshold must not re-admit.
+	h.recordResult(true, unhealthyThreshold, recoveryThreshold)
+	if h.isHealthy() {
+		t.Fatal("replica re-admitted before reaching recoveryThreshold consecutive successes")
+	}
+
+	// The threshold-th consecutive success re-admits.
+	h.recordResult(true, unhealthyThreshold, recoveryThreshold)
+	if !h.isHealthy() {
+		t.Fatal("replica should be re-admitted after recoveryThreshold consecutive successes")
+	}
+}
+
+func TestReplicaHealthLagUnknownUntilSampled(t *testing.T) {
+	h := newReplicaHealth()
+
+	if _, ok := h.lag(); ok {
+		t.Fatal("lag() should report no sample before setLag is ever called")
+	}
+
+	h.setLag(42)
+	lag, ok := h.lag()
+	if !ok {
+		t.Fatal("lag() should report a sample after setLag")
+	}
+	if lag != 42 {
+		t.Fatalf("lag() = %v, want 42", lag)
+	}
+}
+
+func TestDBCloseIsIdempotentWithHealthChecking(t *testing.T) {
+	db, mock := newMockMaster(t)
+	mock.ExpectClose()
+	db.stopHealthCheck = make(chan struct{})
+	db.stopHealthCheckOnce = &sync.Once{}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("first Close() error = %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("second Close() error = %v, want no panic and no error", err)
+	}
+}
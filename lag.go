@@ -0,0 +1,73 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LagSampler measures a slave's current replication lag relative to the
+// master.
+type LagSampler interface {
+	SampleLag(ctx context.Context, slave *sqlx.DB) (time.Duration, error)
+}
+
+// MySQLLagSampler measures lag via the Seconds_Behind_Master column of
+// SHOW SLAVE STATUS.
+type MySQLLagSampler struct{}
+
+// SampleLag implements LagSampler for MySQL/MariaDB replicas.
+func (MySQLLagSampler) SampleLag(ctx context.Context, slave *sqlx.DB) (time.Duration, error) {
+	rows, err := slave.QueryxContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, errors.New("nap: SHOW SLAVE STATUS returned no rows")
+	}
+
+	row := make(map[string]interface{})
+	if err := rows.MapScan(row); err != nil {
+		return 0, err
+	}
+
+	switch secs := row["Seconds_Behind_Master"].(type) {
+	case int64:
+		return time.Duration(secs) * time.Second, nil
+	case nil:
+		return 0, errors.New("nap: replica is not replicating (Seconds_Behind_Master is NULL)")
+	default:
+		return 0, fmt.Errorf("nap: unexpected Seconds_Behind_Master type %T", secs)
+	}
+}
+
+// PostgresLagSampler measures lag as the delta between the slave's wall
+// clock and the timestamp of the last WAL transaction it replayed.
+type PostgresLagSampler struct{}
+
+// SampleLag implements LagSampler for PostgreSQL streaming replicas.
+func (PostgresLagSampler) SampleLag(ctx context.Context, slave *sqlx.DB) (time.Duration, error) {
+	const query = `SELECT EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp()))`
+
+	var seconds sql.NullFloat64
+	if err := slave.QueryRowxContext(ctx, query).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	if !seconds.Valid {
+		// pg_last_xact_replay_timestamp() is NULL both before the first
+		// replayed transaction and, notably, when slave isn't a standby at
+		// all (e.g. a misconfigured DSN pointing at the master). Either way
+		// we have no real lag measurement, so report it the same way
+		// MySQLLagSampler reports a NULL Seconds_Behind_Master: as an
+		// error, not as zero lag.
+		return 0, errors.New("nap: replica is not replicating (pg_last_xact_replay_timestamp is NULL)")
+	}
+
+	return time.Duration(seconds.Float64 * float64(time.Second)), nil
+}
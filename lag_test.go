@@ -0,0 +1,57 @@
+package nap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+func newMockSlave(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+
+	return sqlx.NewDb(mockDB, "sqlmock"), mock
+}
+
+func TestMySQLLagSamplerErrorsOnNullSecondsBehindMaster(t *testing.T) {
+	slave, mock := newMockSlave(t)
+	rows := sqlmock.NewRows([]string{"Seconds_Behind_Master"}).AddRow(nil)
+	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(rows)
+
+	_, err := MySQLLagSampler{}.SampleLag(context.Background(), slave)
+	if err == nil {
+		t.Fatal("SampleLag should error when Seconds_Behind_Master is NULL, not report zero lag")
+	}
+}
+
+func TestPostgresLagSamplerErrorsOnNullReplayTimestamp(t *testing.T) {
+	slave, mock := newMockSlave(t)
+	rows := sqlmock.NewRows([]string{"extract"}).AddRow(nil)
+	mock.ExpectQuery("SELECT EXTRACT").WillReturnRows(rows)
+
+	_, err := PostgresLagSampler{}.SampleLag(context.Background(), slave)
+	if err == nil {
+		t.Fatal("SampleLag should error when pg_last_xact_replay_timestamp is NULL, not report zero lag")
+	}
+}
+
+func TestPostgresLagSamplerReportsLagWhenReplaying(t *testing.T) {
+	slave, mock := newMockSlave(t)
+	rows := sqlmock.NewRows([]string{"extract"}).AddRow(2.5)
+	mock.ExpectQuery("SELECT EXTRACT").WillReturnRows(rows)
+
+	lag, err := PostgresLagSampler{}.SampleLag(context.Background(), slave)
+	if err != nil {
+		t.Fatalf("SampleLag error = %v", err)
+	}
+	if lag.Seconds() != 2.5 {
+		t.Fatalf("SampleLag = %v, want 2.5s", lag)
+	}
+}
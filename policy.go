@@ -0,0 +1,48 @@
+package nap
+
+import "time"
+
+// ReadPolicy controls which physical db a Slave-routed read is allowed to
+// land on.
+type ReadPolicy struct {
+	kind   readPolicyKind
+	maxLag time.Duration
+}
+
+type readPolicyKind int
+
+const (
+	readPolicyAny readPolicyKind = iota
+	readPolicyMaster
+	readPolicyFresh
+)
+
+// PolicyAny lets the Balancer pick any healthy slave, ignoring replication
+// lag. It is the default policy used by Open and OpenWithConfig.
+var PolicyAny = ReadPolicy{kind: readPolicyAny}
+
+// PolicyMaster forces Slave-routed reads to the master. Combined with
+// WithPolicy, this gives the classic read-your-writes pattern right after
+// an Exec.
+var PolicyMaster = ReadPolicy{kind: readPolicyMaster}
+
+// PolicyFresh routes reads to a slave whose most recently sampled
+// replication lag is at most maxLag, falling back to the master if none
+// qualifies. It requires Config.HealthCheckInterval and Config.LagSampler
+// to be set; without lag samples every slave is treated as stale.
+func PolicyFresh(maxLag time.Duration) ReadPolicy {
+	return ReadPolicy{kind: readPolicyFresh, maxLag: maxLag}
+}
+
+// WithPolicy returns a shallow copy of db whose Slave-routed reads honor p,
+// leaving db itself untouched, so e.g.
+// db.WithPolicy(PolicyMaster).Select(...) gives read-your-writes
+// immediately after an Exec without affecting other callers sharing db.
+// The returned *DB shares db's underlying physical connections and
+// health-checker state; calling Close on either one closes both, and
+// calling Close on the other afterwards is safe and returns nil.
+func (db *DB) WithPolicy(p ReadPolicy) *DB {
+	clone := *db
+	clone.policy = p
+	return &clone
+}
@@ -0,0 +1,54 @@
+package nap
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWithPolicyLeavesOriginalUntouched(t *testing.T) {
+	db, _ := newMockMaster(t)
+	db.policy = PolicyAny
+
+	clone := db.WithPolicy(PolicyMaster)
+
+	if clone.policy != PolicyMaster {
+		t.Fatalf("clone.policy = %+v, want PolicyMaster", clone.policy)
+	}
+	if db.policy != PolicyAny {
+		t.Fatalf("db.policy = %+v, want untouched PolicyAny", db.policy)
+	}
+}
+
+func TestWithPolicyClonesSharePhysicalConnections(t *testing.T) {
+	db, _ := newMockMaster(t)
+
+	clone := db.WithPolicy(PolicyMaster)
+
+	if len(clone.pdbs) != len(db.pdbs) {
+		t.Fatalf("clone.pdbs has %d entries, want %d", len(clone.pdbs), len(db.pdbs))
+	}
+	for i := range db.pdbs {
+		if clone.pdbs[i] != db.pdbs[i] {
+			t.Fatalf("clone.pdbs[%d] is a distinct *sqlx.DB, want the same underlying connection", i)
+		}
+	}
+}
+
+func TestDBCloseIsIdempotentAcrossWithPolicyClone(t *testing.T) {
+	// WithPolicy's shallow copy shares stopHealthCheck and its guarding
+	// sync.Once with the original DB, so closing both handles (in either
+	// order) must not double-close the shared channel.
+	db, mock := newMockMaster(t)
+	mock.ExpectClose()
+	db.stopHealthCheck = make(chan struct{})
+	db.stopHealthCheckOnce = &sync.Once{}
+
+	clone := db.WithPolicy(PolicyMaster)
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("db.Close() error = %v", err)
+	}
+	if err := clone.Close(); err != nil {
+		t.Fatalf("clone.Close() error = %v, want no panic (shared stopHealthCheckOnce)", err)
+	}
+}
@@ -0,0 +1,24 @@
+package nap
+
+// scatter calls the given function for each of n items in a separate
+// goroutine, waits for all of them to return, and gives back the first
+// non-nil error received, if any. Errors received after the first are
+// dropped. The first error received is not necessarily from the first call
+// to fail, so a caller that cancels remaining work on failure (e.g.
+// PingContext) records the real failure itself.
+func scatter(n int, fn func(i int) error) error {
+	errs := make(chan error, n)
+
+	for i := 0; i < n; i++ {
+		go func(i int) { errs <- fn(i) }(i)
+	}
+
+	var err error
+	for i := 0; i < n; i++ {
+		if innerErr := <-errs; innerErr != nil && err == nil {
+			err = innerErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,23 @@
+package nap
+
+import "testing"
+
+func TestStatsReturnsOneEntryPerPhysicalDB(t *testing.T) {
+	db, _ := newMockDB(t, 3)
+
+	stats := db.Stats()
+	if len(stats) != 3 {
+		t.Fatalf("Stats() returned %d entries, want 3", len(stats))
+	}
+}
+
+func TestStatsMasterAndStatsSlave(t *testing.T) {
+	db, _ := newMockDB(t, 3)
+
+	if db.StatsMaster() != db.pdbs[0].Stats() {
+		t.Fatal("StatsMaster() should report the master's stats")
+	}
+	if db.StatsSlave(1) != db.pdbs[2].Stats() {
+		t.Fatal("StatsSlave(1) should report pdbs[2]'s stats (the second slave)")
+	}
+}
@@ -0,0 +1,117 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Tx wraps sqlx.Tx. A transaction is always bound to the single physical db
+// it was opened on, so every read or write issued through a Tx obtained from
+// Beginx, BeginTxx or RunInTx goes to the master -- never a slave -- closing
+// the footgun where mixing db.Exec and db.Select mid-transaction could read
+// stale data from a slave.
+type Tx struct {
+	*sqlx.Tx
+}
+
+// Beginx starts a transaction on the master, using sqlx's extended Tx.
+func (db *DB) Beginx() (*Tx, error) {
+	tx, err := db.Master().Beginx()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx}, nil
+}
+
+// BeginTxx starts a transaction on the master with the provided context and
+// options, using sqlx's extended Tx.
+func (db *DB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.Master().BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx}, nil
+}
+
+// RunInTx runs fn inside a transaction opened on the master via BeginTxx,
+// committing on success and rolling back if fn returns an error. If fn or
+// Commit fails with what Config.TxIsRetryable (default
+// IsSerializationFailure) considers a transient serialization failure, the
+// whole transaction is retried with Config.TxBackoff between attempts, up to
+// Config.TxMaxAttempts times.
+func (db *DB) RunInTx(ctx context.Context, opts *sql.TxOptions, fn func(*Tx) error) error {
+	maxAttempts := db.cfg.TxMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	backoff := db.cfg.TxBackoff
+	if backoff == nil {
+		backoff = func(attempt int) time.Duration { return time.Duration(attempt) * 20 * time.Millisecond }
+	}
+	isRetryable := db.cfg.TxIsRetryable
+	if isRetryable == nil {
+		isRetryable = IsSerializationFailure
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = db.runInTxOnce(ctx, opts, fn); err == nil {
+			return nil
+		}
+		if !isRetryable(err) || attempt == maxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff(attempt)):
+		}
+	}
+
+	return err
+}
+
+func (db *DB) runInTxOnce(ctx context.Context, opts *sql.TxOptions, fn func(*Tx) error) (err error) {
+	tx, err := db.BeginTxx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsSerializationFailure reports whether err looks like a transient
+// serialization failure (SQLSTATE 40001), deadlock (SQLSTATE 40P01 or MySQL
+// error 1213) or MySQL lock wait timeout (error 1205) -- the codes Postgres
+// and most MySQL drivers surface for a transaction that should simply be
+// retried. It matches on the SQLSTATE or MySQL error
+// number embedded in err's message, since nap avoids a hard dependency on
+// any particular driver package; callers whose driver exposes a structured
+// error code should set Config.TxIsRetryable instead.
+func IsSerializationFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "40001") || // Postgres: serialization_failure
+		strings.Contains(msg, "40P01") || // Postgres: deadlock_detected
+		strings.Contains(msg, "Error 1213") || // MySQL: deadlock found
+		strings.Contains(msg, "Error 1205") // MySQL: lock wait timeout exceeded
+}
@@ -0,0 +1,128 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+func newMockMaster(t *testing.T) (*DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+
+	return &DB{pdbs: []*sqlx.DB{sqlx.NewDb(mockDB, "sqlmock")}}, mock
+}
+
+var errNotRetryable = errors.New("boom")
+
+func TestRunInTxRetriesOnlyRetryableErrors(t *testing.T) {
+	db, mock := newMockMaster(t)
+	db.cfg.TxBackoff = func(int) time.Duration { return 0 }
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	var calls int
+	err := db.RunInTx(context.Background(), nil, func(tx *Tx) error {
+		calls++
+		return errNotRetryable
+	})
+
+	if !errors.Is(err, errNotRetryable) {
+		t.Fatalf("RunInTx() error = %v, want %v", err, errNotRetryable)
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1 (non-retryable error must not retry)", calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRunInTxRetriesRetryableErrorUpToMaxAttempts(t *testing.T) {
+	db, mock := newMockMaster(t)
+	db.cfg.TxMaxAttempts = 3
+	db.cfg.TxBackoff = func(int) time.Duration { return 0 }
+
+	for i := 0; i < 3; i++ {
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+	}
+
+	var calls int
+	err := db.RunInTx(context.Background(), nil, func(tx *Tx) error {
+		calls++
+		return errors.New("SQLSTATE 40001")
+	})
+
+	if err == nil {
+		t.Fatal("RunInTx() error = nil, want the last retryable error")
+	}
+	if calls != 3 {
+		t.Fatalf("fn called %d times, want 3 (= TxMaxAttempts)", calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRunInTxSucceedsAfterRetry(t *testing.T) {
+	db, mock := newMockMaster(t)
+	db.cfg.TxBackoff = func(int) time.Duration { return 0 }
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	var calls int
+	err := db.RunInTx(context.Background(), nil, func(tx *Tx) error {
+		calls++
+		if calls == 1 {
+			return errors.New("SQLSTATE 40P01")
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("RunInTx() error = %v, want nil", err)
+	}
+	if calls != 2 {
+		t.Fatalf("fn called %d times, want 2", calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestIsSerializationFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"postgres serialization_failure", errors.New("pq: could not serialize access due to concurrent update (SQLSTATE 40001)"), true},
+		{"postgres deadlock_detected", errors.New("pq: deadlock detected (SQLSTATE 40P01)"), true},
+		{"mysql deadlock", errors.New("Error 1213: Deadlock found when trying to get lock"), true},
+		{"mysql lock wait timeout", errors.New("Error 1205: Lock wait timeout exceeded"), true},
+		{"unrelated error", errNotRetryable, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsSerializationFailure(tt.err); got != tt.want {
+				t.Errorf("IsSerializationFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}